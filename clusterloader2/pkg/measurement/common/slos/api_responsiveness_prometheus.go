@@ -68,12 +68,68 @@ const (
 	// exclude all buckets below or equal 30s
 	filterClusterList = `scope="cluster", verb="LIST", le!~"[12]?[0-9](.\\d+)?|30"`
 
+	// histogramType selects which Prometheus histogram representation the latency
+	// and slow-count queries are built from. "auto" probes the target Prometheus
+	// for native (sparse) histogram series and falls back to "classic" otherwise.
+	histogramTypeClassic = "classic"
+	histogramTypeNative  = "native"
+	histogramTypeAuto    = "auto"
+
+	// nativeLatencyQuery computes the percentile directly off the native (sparse)
+	// histogram series exposed for apiserver_request_duration_seconds, so it doesn't
+	// need the le bucket-boundary filters the classic path relies on.
+	//
+	// nativeLatencyQuery: placeholders should be replaced with (1) quantile (2) filters and (3) query window size.
+	nativeLatencyQuery = "histogram_quantile(%.2f, sum(rate(apiserver_request_duration_seconds{%v}[%v])) by (resource, subresource, verb, scope))"
+
+	// nativeSLILatencyQuery is the native-histogram equivalent of latencyQuery: there's
+	// no apiserver:..._1m recording rule for native histograms, so the 1m quantile is
+	// computed directly and aggregated over 5m windows with quantile_over_time instead.
+	//
+	// nativeSLILatencyQuery: placeholders should be replaced with (1) filters and (2) query window size.
+	nativeSLILatencyQuery = "quantile_over_time(0.99, (histogram_quantile(0.99, sum(rate(apiserver_request_duration_seconds{%v}[1m])) by (resource, subresource, verb, scope)))[%v:1m])"
+
+	// nativeCountQuery is the native-histogram equivalent of countQuery.
+	//
+	// nativeCountQuery: %v should be replaced with (1) filters and (2) query window size.
+	nativeCountQuery = "sum(histogram_count(increase(apiserver_request_duration_seconds{%v}[%v]))) by (resource, subresource, scope, verb)"
+
+	// nativeCountSlowQuery computes the number of requests slower than a threshold
+	// straight from the native histogram via histogram_count/histogram_fraction,
+	// which avoids the bucket-boundary rounding errors the le!~"..." filters have.
+	//
+	// nativeCountSlowQuery: %[1]v/%[2]v/%[3]v should be replaced with (1) filters, (2) query window size and (3) threshold in seconds.
+	nativeCountSlowQuery = "sum(histogram_count(rate(apiserver_request_duration_seconds{%[1]v}[%[2]v])) - histogram_fraction(0, %[3]v, rate(apiserver_request_duration_seconds{%[1]v}[%[2]v]))*histogram_count(rate(apiserver_request_duration_seconds{%[1]v}[%[2]v]))) by (resource, subresource, scope, verb)"
+
+	// native equivalents of filterGetAndMutating/filterNamespaceList/filterClusterList:
+	// since there are no buckets to filter on, requests are grouped by verb/scope instead.
+	nativeFilterGetAndMutating = `verb!~"WATCH|WATCHLIST|PROXY|CONNECT", verb!="LIST"`
+	nativeFilterNamespaceList  = `scope!="cluster", verb="LIST"`
+	nativeFilterClusterList    = `scope="cluster", verb="LIST"`
+
+	// probeNativeHistogramQuery detects whether apiserver_request_duration_seconds is
+	// currently exposed as a native histogram: histogram_count() over a classic,
+	// bucketed series returns no samples, while it does for a native one.
+	probeNativeHistogramQuery = "histogram_count(rate(apiserver_request_duration_seconds{%v}[%v]))"
+
 	latencyWindowSize = 5 * time.Minute
 
 	// Number of metrics with highest latency to print. If the latency exceeeds SLO threshold, a metric is printed regardless.
 	topToPrint = 5
 )
 
+// nativeSlowCountFilters pairs each native slow-count filter with the SLO
+// threshold it corresponds to, mirroring the resourceThreshold/namespaceThreshold/
+// clusterThreshold tiers getSLOThreshold() uses for the classic path.
+var nativeSlowCountFilters = []struct {
+	filter    string
+	threshold time.Duration
+}{
+	{nativeFilterGetAndMutating, resourceThreshold},
+	{nativeFilterNamespaceList, namespaceThreshold},
+	{nativeFilterClusterList, clusterThreshold},
+}
+
 func init() {
 	create := func() measurement.Measurement { return createPrometheusMeasurement(&apiResponsivenessGatherer{}) }
 	if err := measurement.Register(apiResponsivenessPrometheusMeasurementName, create); err != nil {
@@ -98,7 +154,16 @@ type apiCallMetrics struct {
 type apiResponsivenessGatherer struct{}
 
 func (a *apiResponsivenessGatherer) Gather(executor QueryExecutor, startTime time.Time, config *measurement.MeasurementConfig) (measurement.Summary, error) {
-	apiCalls, err := a.gatherAPICalls(executor, startTime, config)
+	allowedSlowCalls, err := util.GetIntOrDefault(config.Params, "allowedSlowCalls", 0)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := loadThresholdPolicy(config.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	apiCalls, err := a.gatherAPICalls(executor, startTime, config, allowedSlowCalls, policy)
 	if err != nil {
 		return nil, err
 	}
@@ -113,14 +178,27 @@ func (a *apiResponsivenessGatherer) Gather(executor QueryExecutor, startTime tim
 	}
 	summary := measurement.CreateSummary(summaryName, "json", content)
 
-	allowedSlowCalls, err := util.GetIntOrDefault(config.Params, "allowedSlowCalls", 0)
+	// A failed export shouldn't throw away an otherwise-successful measurement.
+	if err := exportAPICalls(config.Params, config.Identifier, apiCalls); err != nil {
+		klog.Warningf("%s: failed to export API calls: %v", config.Identifier, err)
+	}
+
+	badMetrics := a.validateAPICalls(config.Identifier, allowedSlowCalls, policy, apiCalls)
+
+	regressions, err := detectRegressions(config.Params, apiCalls)
 	if err != nil {
-		return nil, err
+		return summary, err
 	}
 
-	badMetrics := a.validateAPICalls(config.Identifier, allowedSlowCalls, apiCalls)
-	if len(badMetrics) > 0 {
+	switch {
+	case len(badMetrics) > 0:
 		err = errors.NewMetricViolationError("top latency metric", fmt.Sprintf("there should be no high-latency requests, but: %v", badMetrics))
+	case len(regressions) > 0:
+		// Reported as a distinct violation from SLO breaches above, so CI can tell
+		// "still within SLO but got slower" apart from an actual SLO breach.
+		err = errors.NewMetricViolationError("latency regression", fmt.Sprintf("%d call(s) regressed against the baseline run: %v", len(regressions), regressions))
+	default:
+		err = nil
 	}
 	return summary, err
 }
@@ -133,7 +211,7 @@ func (a *apiResponsivenessGatherer) IsEnabled(config *measurement.MeasurementCon
 	return true
 }
 
-func (a *apiResponsivenessGatherer) gatherAPICalls(executor QueryExecutor, startTime time.Time, config *measurement.MeasurementConfig) (*apiCallMetrics, error) {
+func (a *apiResponsivenessGatherer) gatherAPICalls(executor QueryExecutor, startTime time.Time, config *measurement.MeasurementConfig, allowedSlowCalls int, policy *ThresholdPolicy) (*apiCallMetrics, error) {
 	measurementEnd := time.Now()
 	measurementDuration := measurementEnd.Sub(startTime)
 	promDuration := measurementutil.ToPrometheusTime(measurementDuration)
@@ -143,11 +221,38 @@ func (a *apiResponsivenessGatherer) gatherAPICalls(executor QueryExecutor, start
 		return nil, err
 	}
 
+	histogramType, err := util.GetStringOrDefault(config.Params, "histogramType", histogramTypeClassic)
+	if err != nil {
+		return nil, err
+	}
+	if histogramType == histogramTypeAuto {
+		histogramType = a.detectHistogramType(executor, measurementEnd)
+	}
+
+	// Only run the slow-count queries if something can use the result: either the
+	// top-level allowedSlowCalls budget, or a policy override granting one.
+	measureSlowCalls := allowedSlowCalls != 0 || policy.HasSlowCallBudget()
+
+	chunkDuration, err := util.GetDurationOrDefault(config.Params, "queryChunkDuration", defaultQueryChunkDuration)
+	if err != nil {
+		return nil, err
+	}
+	// For multi-day runs, a single query over the whole measurementDuration can OOM
+	// Prometheus or time out; above the chunk size, split the range into windows.
+	if measurementDuration > chunkDuration {
+		chunked := NewChunkedQueryExecutor(executor, chunkDuration)
+		return a.gatherAPICallsChunked(chunked, startTime, measurementEnd, useSimple, histogramType, measureSlowCalls)
+	}
+
 	var latencySamples []*model.Sample
 	if useSimple {
 		quantiles := []float64{0.5, 0.9, 0.99}
+		simpleQuery := simpleLatencyQuery
+		if histogramType == histogramTypeNative {
+			simpleQuery = nativeLatencyQuery
+		}
 		for _, q := range quantiles {
-			query := fmt.Sprintf(simpleLatencyQuery, q, filters, promDuration)
+			query := fmt.Sprintf(simpleQuery, q, filters, promDuration)
 			samples, err := executor.Query(query, measurementEnd)
 			if err != nil {
 				return nil, err
@@ -167,48 +272,81 @@ func (a *apiResponsivenessGatherer) gatherAPICalls(executor QueryExecutor, start
 		}
 		duration := measurementutil.ToPrometheusTime(latencyMeasurementDuration)
 
-		query := fmt.Sprintf(latencyQuery, filters, duration)
+		sliQuery := latencyQuery
+		if histogramType == histogramTypeNative {
+			sliQuery = nativeSLILatencyQuery
+		}
+		query := fmt.Sprintf(sliQuery, filters, duration)
 		latencySamples, err = executor.Query(query, measurementEnd)
 		if err != nil {
 			return nil, err
 		}
+		if histogramType == histogramTypeNative {
+			// Underlying code assumes presence of 'quantile' label, which
+			// nativeSLILatencyQuery's result lacks, so adding it manually.
+			for _, sample := range latencySamples {
+				sample.Metric["quantile"] = "0.99"
+			}
+		}
 	}
 
-	query := fmt.Sprintf(countQuery, filters, promDuration)
-	countSamples, err := executor.Query(query, measurementEnd)
-	if err != nil {
-		return nil, err
+	countQueryTemplate := countQuery
+	if histogramType == histogramTypeNative {
+		countQueryTemplate = nativeCountQuery
 	}
-
-	allowedSlowCalls, err := util.GetIntOrDefault(config.Params, "allowedSlowCalls", 0)
+	query := fmt.Sprintf(countQueryTemplate, filters, promDuration)
+	countSamples, err := executor.Query(query, measurementEnd)
 	if err != nil {
 		return nil, err
 	}
 
 	countSlowSamples := make([]*model.Sample, 0)
 	// TODO(oxddr): remove this guard once it's stable
-	if allowedSlowCalls != 0 {
-		filters := []string{filterGetAndMutating, filterNamespaceList, filterClusterList}
-		for _, filter := range filters {
-			query := fmt.Sprintf(countSlowQuery, filter, promDuration)
-			samples, err := executor.Query(query, measurementEnd)
-			if err != nil {
-				return nil, err
+	if measureSlowCalls {
+		if histogramType == histogramTypeNative {
+			for _, f := range nativeSlowCountFilters {
+				query := fmt.Sprintf(nativeCountSlowQuery, f.filter, promDuration, f.threshold.Seconds())
+				samples, err := executor.Query(query, measurementEnd)
+				if err != nil {
+					return nil, err
+				}
+				countSlowSamples = append(countSlowSamples, samples...)
+			}
+		} else {
+			filters := []string{filterGetAndMutating, filterNamespaceList, filterClusterList}
+			for _, filter := range filters {
+				query := fmt.Sprintf(countSlowQuery, filter, promDuration)
+				samples, err := executor.Query(query, measurementEnd)
+				if err != nil {
+					return nil, err
+				}
+				countSlowSamples = append(countSlowSamples, samples...)
 			}
-			countSlowSamples = append(countSlowSamples, samples...)
 		}
 	}
 
 	return newFromSamples(latencySamples, countSamples, countSlowSamples)
 }
 
-func (a *apiResponsivenessGatherer) validateAPICalls(identifier string, allowedSlowCalls int, metrics *apiCallMetrics) []error {
+// detectHistogramType probes the target Prometheus for native (sparse) histogram
+// series and returns histogramTypeNative if present, falling back to histogramTypeClassic
+// otherwise (e.g. on query errors, or when the apiserver only exposes classic buckets).
+func (a *apiResponsivenessGatherer) detectHistogramType(executor QueryExecutor, now time.Time) string {
+	query := fmt.Sprintf(probeNativeHistogramQuery, filters, measurementutil.ToPrometheusTime(latencyWindowSize))
+	samples, err := executor.Query(query, now)
+	if err != nil || len(samples) == 0 {
+		return histogramTypeClassic
+	}
+	return histogramTypeNative
+}
+
+func (a *apiResponsivenessGatherer) validateAPICalls(identifier string, allowedSlowCalls int, policy *ThresholdPolicy, metrics *apiCallMetrics) []error {
 	badMetrics := make([]error, 0)
 	top := topToPrint
 
 	for _, apiCall := range metrics.sorted() {
 		var err error
-		if err = apiCall.Validate(allowedSlowCalls); err != nil {
+		if err = apiCall.Validate(allowedSlowCalls, policy); err != nil {
 			badMetrics = append(badMetrics, err)
 		}
 		if top > 0 || err != nil {
@@ -223,11 +361,13 @@ func (a *apiResponsivenessGatherer) validateAPICalls(identifier string, allowedS
 	return badMetrics
 }
 
-func newFromSamples(latencySamples, countSamples, countSlowSamples []*model.Sample) (*apiCallMetrics, error) {
-	extractCommon := func(sample *model.Sample) (string, string, string, string) {
-		return string(sample.Metric["resource"]), string(sample.Metric["subresource"]), string(sample.Metric["verb"]), string(sample.Metric["scope"])
-	}
+// extractCommon pulls the resource/subresource/verb/scope labels common to every
+// apiCallMetric query (latency, count and slow-count alike) out of a sample.
+func extractCommon(sample *model.Sample) (string, string, string, string) {
+	return string(sample.Metric["resource"]), string(sample.Metric["subresource"]), string(sample.Metric["verb"]), string(sample.Metric["scope"])
+}
 
+func newFromSamples(latencySamples, countSamples, countSlowSamples []*model.Sample) (*apiCallMetrics, error) {
 	m := &apiCallMetrics{metrics: make(map[string]*apiCallMetric)}
 
 	for _, sample := range latencySamples {
@@ -331,8 +471,11 @@ func (m *apiCallMetrics) buildKey(resource, subresource, verb, scope string) str
 	return fmt.Sprintf("%s|%s|%s|%s", resource, subresource, verb, scope)
 }
 
-func (ap *apiCallMetric) Validate(allowedSlowCalls int) error {
-	threshold := ap.getSLOThreshold()
+func (ap *apiCallMetric) Validate(allowedSlowCalls int, policy *ThresholdPolicy) error {
+	threshold, policyAllowedSlowCalls := policy.Threshold(ap.Resource, ap.Subresource, ap.Verb, ap.Scope, ap.getSLOThreshold())
+	if policyAllowedSlowCalls > allowedSlowCalls {
+		allowedSlowCalls = policyAllowedSlowCalls
+	}
 	if err := ap.Latency.VerifyThreshold(threshold); err != nil {
 		// TODO(oxddr): remove allowedSlowCalls guard once it's stable
 		if allowedSlowCalls > 0 && ap.SlowCount <= allowedSlowCalls {
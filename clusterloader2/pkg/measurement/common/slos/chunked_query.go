@@ -0,0 +1,318 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"k8s.io/klog"
+
+	measurementutil "k8s.io/perf-tests/clusterloader2/pkg/measurement/util"
+)
+
+const (
+	// defaultQueryChunkDuration is used when "queryChunkDuration" isn't set.
+	defaultQueryChunkDuration = 1 * time.Hour
+
+	queryChunkMaxRetries   = 3
+	queryChunkRetryBackoff = 5 * time.Second
+)
+
+// queryWindow is a half-open [start, end) time range queried as a single chunk.
+type queryWindow struct {
+	start, end time.Time
+}
+
+// ChunkedQueryExecutor wraps a QueryExecutor and splits a query covering a long
+// [start, end) range into fixed-size windows, each issued (with retry/backoff) as
+// its own QueryExecutor.Query call.
+type ChunkedQueryExecutor struct {
+	executor      QueryExecutor
+	chunkDuration time.Duration
+}
+
+// NewChunkedQueryExecutor wraps executor, splitting queries into chunkDuration
+// windows (defaultQueryChunkDuration if chunkDuration is non-positive).
+func NewChunkedQueryExecutor(executor QueryExecutor, chunkDuration time.Duration) *ChunkedQueryExecutor {
+	if chunkDuration <= 0 {
+		chunkDuration = defaultQueryChunkDuration
+	}
+	return &ChunkedQueryExecutor{executor: executor, chunkDuration: chunkDuration}
+}
+
+// windows splits [start, end) into c.chunkDuration-sized, half-open windows.
+func (c *ChunkedQueryExecutor) windows(start, end time.Time) []queryWindow {
+	var windows []queryWindow
+	for windowStart := start; windowStart.Before(end); windowStart = windowStart.Add(c.chunkDuration) {
+		windowEnd := windowStart.Add(c.chunkDuration)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		windows = append(windows, queryWindow{windowStart, windowEnd})
+	}
+	return windows
+}
+
+// Query runs query, retrying with backoff on error, same signature as QueryExecutor
+// so a single chunk's query can be issued the same way as an unchunked one.
+func (c *ChunkedQueryExecutor) Query(query string, queryTime time.Time) ([]*model.Sample, error) {
+	var lastErr error
+	for attempt := 0; attempt <= queryChunkMaxRetries; attempt++ {
+		if attempt > 0 {
+			klog.Warningf("retrying chunked query (attempt %d/%d) after error: %v", attempt, queryChunkMaxRetries, lastErr)
+			time.Sleep(queryChunkRetryBackoff)
+		}
+		samples, err := c.executor.Query(query, queryTime)
+		if err == nil {
+			return samples, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// gatherAPICallsChunked is the chunked equivalent of gatherAPICalls, used once the
+// measurement window exceeds chunked.chunkDuration. Counts are summed across chunks;
+// latency quantiles are merged as a running average weighted by each chunk's request
+// count, and the rate()-based slow-call count as a chunk-duration-weighted running
+// average, since rate() doesn't accumulate.
+func (a *apiResponsivenessGatherer) gatherAPICallsChunked(chunked *ChunkedQueryExecutor, startTime, measurementEnd time.Time, useSimple bool, histogramType string, measureSlowCalls bool) (*apiCallMetrics, error) {
+	m := &apiCallMetrics{metrics: make(map[string]*apiCallMetric)}
+	latencyEstimate := make(map[string]*runningLatencyEstimate)
+	slowCountEstimate := make(map[string]*runningRateEstimate)
+
+	windows := chunked.windows(startTime, measurementEnd)
+	for i, w := range windows {
+		duration := measurementutil.ToPrometheusTime(w.end.Sub(w.start))
+
+		chunkCounts, err := a.queryChunkCounts(chunked, duration, w.end, histogramType)
+		if err != nil {
+			return nil, err
+		}
+		for key, count := range chunkCounts {
+			m.getAPICall(key.resource, key.subresource, key.verb, key.scope).Count += count
+		}
+
+		if measureSlowCalls {
+			if err := a.accumulateChunkSlowCounts(chunked, duration, w.end, w.end.Sub(w.start).Seconds(), histogramType, slowCountEstimate); err != nil {
+				return nil, err
+			}
+		}
+
+		// The "SLI" query needs a 5m warm-up, so (like the unchunked path) the
+		// first chunk's window is shrunk to skip the measurement's first 5 minutes;
+		// later chunks start well past that point already.
+		latencyWindow := w
+		if !useSimple && i == 0 {
+			latencyWindow = firstLatencyWindow(w)
+		}
+		latencySamples, err := a.queryLatencyChunk(chunked, latencyWindow, useSimple, histogramType)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range latencySamples {
+			resource, subresource, verb, scope := extractCommon(sample)
+			quantile, err := strconv.ParseFloat(string(sample.Metric["quantile"]), 64)
+			if err != nil {
+				return nil, err
+			}
+			latency := time.Duration(float64(sample.Value) * float64(time.Second))
+
+			key := apiCallKey{resource, subresource, verb, scope, quantile}
+			weight := float64(chunkCounts[apiCallKey{resource, subresource, verb, scope, 0}])
+			if weight == 0 {
+				weight = 1
+			}
+			estimate, exists := latencyEstimate[key.string()]
+			if !exists {
+				estimate = &runningLatencyEstimate{}
+				latencyEstimate[key.string()] = estimate
+			}
+			estimate.weightedSum += latency.Seconds() * weight
+			estimate.totalWeight += weight
+			estimate.resource, estimate.subresource, estimate.verb, estimate.scope, estimate.quantile = resource, subresource, verb, scope, quantile
+		}
+	}
+
+	for _, estimate := range latencyEstimate {
+		if estimate.totalWeight == 0 {
+			continue
+		}
+		avgSeconds := estimate.weightedSum / estimate.totalWeight
+		m.SetLatency(estimate.resource, estimate.subresource, estimate.verb, estimate.scope, estimate.quantile, time.Duration(avgSeconds*float64(time.Second)))
+	}
+	for _, estimate := range slowCountEstimate {
+		if estimate.totalWeight == 0 {
+			continue
+		}
+		avgRate := estimate.weightedSum / estimate.totalWeight
+		call := m.getAPICall(estimate.resource, estimate.subresource, estimate.verb, estimate.scope)
+		call.SlowCount = int(math.Round(avgRate))
+	}
+
+	return m, nil
+}
+
+// firstLatencyWindow mirrors the unchunked path's "skip the first 5 minutes" warm-up
+// adjustment: it keeps w's end and moves its start forward by latencyWindowSize
+// (the remaining window clamped to at least a minute).
+func firstLatencyWindow(w queryWindow) queryWindow {
+	duration := w.end.Sub(w.start) - latencyWindowSize
+	if duration < time.Minute {
+		duration = time.Minute
+	}
+	return queryWindow{start: w.end.Add(-duration), end: w.end}
+}
+
+// apiCallKey identifies a single (resource, subresource, verb, scope) call site,
+// optionally tagged with a latency quantile; the zero quantile is used as the key
+// for the plain request count of that call site.
+type apiCallKey struct {
+	resource, subresource, verb, scope string
+	quantile                           float64
+}
+
+func (k apiCallKey) string() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%.2f", k.resource, k.subresource, k.verb, k.scope, k.quantile)
+}
+
+// runningLatencyEstimate accumulates a count-weighted average latency, in seconds,
+// for a single (resource, subresource, verb, scope, quantile) across chunks.
+type runningLatencyEstimate struct {
+	resource, subresource, verb, scope string
+	quantile                           float64
+	weightedSum, totalWeight           float64
+}
+
+// runningRateEstimate accumulates a duration-weighted average of a rate()-based
+// value (e.g. the slow-call count) for a single (resource, subresource, verb,
+// scope) across chunks, since rate() values - unlike increase() - don't sum.
+type runningRateEstimate struct {
+	resource, subresource, verb, scope string
+	weightedSum, totalWeight           float64
+}
+
+// queryChunkCounts runs countQuery (or nativeCountQuery, depending on histogramType)
+// for a single chunk and returns the resulting counts keyed by apiCallKey (with a
+// zero quantile, since counts aren't per-quantile).
+func (a *apiResponsivenessGatherer) queryChunkCounts(chunked *ChunkedQueryExecutor, duration string, queryTime time.Time, histogramType string) (map[apiCallKey]int, error) {
+	countQueryTemplate := countQuery
+	if histogramType == histogramTypeNative {
+		countQueryTemplate = nativeCountQuery
+	}
+	query := fmt.Sprintf(countQueryTemplate, filters, duration)
+	samples, err := chunked.Query(query, queryTime)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[apiCallKey]int, len(samples))
+	for _, sample := range samples {
+		resource, subresource, verb, scope := extractCommon(sample)
+		counts[apiCallKey{resource, subresource, verb, scope, 0}] = int(math.Round(float64(sample.Value)))
+	}
+	return counts, nil
+}
+
+// accumulateChunkSlowCounts runs the slow-count queries (classic or native,
+// depending on histogramType) for a single chunk and folds the results - each a
+// rate(), not an accumulator - into estimate as a durationSeconds-weighted average.
+func (a *apiResponsivenessGatherer) accumulateChunkSlowCounts(chunked *ChunkedQueryExecutor, duration string, queryTime time.Time, durationSeconds float64, histogramType string, estimate map[string]*runningRateEstimate) error {
+	addSamples := func(samples []*model.Sample) {
+		for _, sample := range samples {
+			resource, subresource, verb, scope := extractCommon(sample)
+			key := apiCallKey{resource, subresource, verb, scope, 0}.string()
+			e, exists := estimate[key]
+			if !exists {
+				e = &runningRateEstimate{resource: resource, subresource: subresource, verb: verb, scope: scope}
+				estimate[key] = e
+			}
+			e.weightedSum += float64(sample.Value) * durationSeconds
+			e.totalWeight += durationSeconds
+		}
+	}
+
+	if histogramType == histogramTypeNative {
+		for _, f := range nativeSlowCountFilters {
+			query := fmt.Sprintf(nativeCountSlowQuery, f.filter, duration, f.threshold.Seconds())
+			samples, err := chunked.Query(query, queryTime)
+			if err != nil {
+				return err
+			}
+			addSamples(samples)
+		}
+		return nil
+	}
+
+	for _, filter := range []string{filterGetAndMutating, filterNamespaceList, filterClusterList} {
+		query := fmt.Sprintf(countSlowQuery, filter, duration)
+		samples, err := chunked.Query(query, queryTime)
+		if err != nil {
+			return err
+		}
+		addSamples(samples)
+	}
+	return nil
+}
+
+// queryLatencyChunk runs the latency query for a single chunk window, mirroring
+// the useSimple/histogramType branching gatherAPICalls uses for the unchunked path.
+func (a *apiResponsivenessGatherer) queryLatencyChunk(chunked *ChunkedQueryExecutor, w queryWindow, useSimple bool, histogramType string) ([]*model.Sample, error) {
+	duration := measurementutil.ToPrometheusTime(w.end.Sub(w.start))
+
+	if !useSimple {
+		sliQuery := latencyQuery
+		if histogramType == histogramTypeNative {
+			sliQuery = nativeSLILatencyQuery
+		}
+		query := fmt.Sprintf(sliQuery, filters, duration)
+		samples, err := chunked.Query(query, w.end)
+		if err != nil {
+			return nil, err
+		}
+		if histogramType == histogramTypeNative {
+			// Underlying code assumes presence of 'quantile' label, which
+			// nativeSLILatencyQuery's result lacks, so adding it manually.
+			for _, sample := range samples {
+				sample.Metric["quantile"] = "0.99"
+			}
+		}
+		return samples, nil
+	}
+
+	simpleQuery := simpleLatencyQuery
+	if histogramType == histogramTypeNative {
+		simpleQuery = nativeLatencyQuery
+	}
+	var samples []*model.Sample
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		query := fmt.Sprintf(simpleQuery, q, filters, duration)
+		chunkSamples, err := chunked.Query(query, w.end)
+		if err != nil {
+			return nil, err
+		}
+		// Underlying code assumes presence of 'quantile' label, so adding it manually.
+		for _, sample := range chunkSamples {
+			sample.Metric["quantile"] = model.LabelValue(fmt.Sprintf("%.2f", q))
+		}
+		samples = append(samples, chunkSamples...)
+	}
+	return samples, nil
+}
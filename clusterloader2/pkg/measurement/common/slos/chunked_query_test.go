@@ -0,0 +1,95 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunkedQueryExecutorWindows(t *testing.T) {
+	start := time.Unix(0, 0).UTC()
+
+	tests := []struct {
+		name          string
+		duration      time.Duration
+		chunkDuration time.Duration
+		wantWindows   int
+	}{
+		{"exact multiple", 2 * time.Hour, time.Hour, 2},
+		{"partial last window", 90 * time.Minute, time.Hour, 2},
+		{"shorter than one chunk", 30 * time.Minute, time.Hour, 1},
+		{"zero duration", 0, time.Hour, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewChunkedQueryExecutor(nil, tc.chunkDuration)
+			end := start.Add(tc.duration)
+			windows := c.windows(start, end)
+			if len(windows) != tc.wantWindows {
+				t.Fatalf("got %d windows, want %d", len(windows), tc.wantWindows)
+			}
+			if len(windows) == 0 {
+				return
+			}
+			if !windows[0].start.Equal(start) {
+				t.Errorf("first window should start at %v, got %v", start, windows[0].start)
+			}
+			if last := windows[len(windows)-1]; !last.end.Equal(end) {
+				t.Errorf("last window should end at %v, got %v", end, last.end)
+			}
+			for i := 1; i < len(windows); i++ {
+				if !windows[i-1].end.Equal(windows[i].start) {
+					t.Errorf("window %d should start where window %d ends: %v != %v", i, i-1, windows[i].start, windows[i-1].end)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedQueryExecutorWindowsDefaultsChunkDuration(t *testing.T) {
+	c := NewChunkedQueryExecutor(nil, 0)
+	if c.chunkDuration != defaultQueryChunkDuration {
+		t.Errorf("got chunkDuration %v, want default %v", c.chunkDuration, defaultQueryChunkDuration)
+	}
+}
+
+func TestFirstLatencyWindow(t *testing.T) {
+	end := time.Unix(0, 0).UTC()
+
+	tests := []struct {
+		name       string
+		windowSize time.Duration
+		wantStart  time.Duration // duration before end
+	}{
+		{"window bigger than latencyWindowSize keeps the remainder", time.Hour, time.Hour - latencyWindowSize},
+		{"window smaller than latencyWindowSize clamps to a minute", 2 * time.Minute, time.Minute},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := firstLatencyWindow(queryWindow{start: end.Add(-tc.windowSize), end: end})
+			if !w.end.Equal(end) {
+				t.Errorf("end should be unchanged: got %v, want %v", w.end, end)
+			}
+			if got := end.Sub(w.start); got != tc.wantStart {
+				t.Errorf("got window of %v, want %v", got, tc.wantStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/prometheus/prompb"
+
+	"k8s.io/perf-tests/clusterloader2/pkg/util"
+)
+
+const (
+	exporterFormatPushgateway = "pushgateway"
+	exporterFormatRemoteWrite = "remote_write"
+
+	exportedLatencyMetricName   = "clusterloader_apicall_latency_seconds"
+	exportedCountMetricName     = "clusterloader_apicall_count"
+	exportedSlowCountMetricName = "clusterloader_apicall_slow_count"
+
+	pushgatewayJobName = "clusterloader2"
+)
+
+// exportAPICalls publishes apiCalls as Prometheus samples to the endpoint configured
+// via the "prometheusExportURL"/"prometheusExportFormat" measurement params. It's a
+// no-op when no URL is set.
+func exportAPICalls(params map[string]interface{}, identifier string, apiCalls *apiCallMetrics) error {
+	url, err := util.GetStringOrDefault(params, "prometheusExportURL", "")
+	if err != nil || url == "" {
+		return err
+	}
+	format, err := util.GetStringOrDefault(params, "prometheusExportFormat", exporterFormatPushgateway)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case exporterFormatPushgateway:
+		return pushToPushgateway(url, identifier, apiCalls)
+	case exporterFormatRemoteWrite:
+		return remoteWriteAPICalls(url, identifier, apiCalls)
+	default:
+		return fmt.Errorf("unknown prometheusExportFormat %q", format)
+	}
+}
+
+// quantileLatenciesSeconds returns the call's quantile labels (matching ToPerfData)
+// mapped to their latency in seconds, the unit Prometheus samples are expected in.
+func quantileLatenciesSeconds(apiCall *apiCallMetric) map[string]float64 {
+	return map[string]float64{
+		"0.5":  apiCall.Latency.Perc50.Seconds(),
+		"0.9":  apiCall.Latency.Perc90.Seconds(),
+		"0.99": apiCall.Latency.Perc99.Seconds(),
+	}
+}
+
+func pushToPushgateway(url, identifier string, apiCalls *apiCallMetrics) error {
+	labelNames := []string{"verb", "resource", "subresource", "scope"}
+	latency := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: exportedLatencyMetricName}, append(labelNames, "quantile"))
+	count := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: exportedCountMetricName}, labelNames)
+	slowCount := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: exportedSlowCountMetricName}, labelNames)
+
+	for _, apiCall := range apiCalls.sorted() {
+		labels := prometheus.Labels{
+			"verb":        apiCall.Verb,
+			"resource":    apiCall.Resource,
+			"subresource": apiCall.Subresource,
+			"scope":       apiCall.Scope,
+		}
+		for quantile, value := range quantileLatenciesSeconds(apiCall) {
+			latency.With(mergeLabels(labels, prometheus.Labels{"quantile": quantile})).Set(value)
+		}
+		count.With(labels).Set(float64(apiCall.Count))
+		slowCount.With(labels).Set(float64(apiCall.SlowCount))
+	}
+
+	return push.New(url, pushgatewayJobName).
+		Grouping("run", identifier).
+		Collector(latency).
+		Collector(count).
+		Collector(slowCount).
+		Push()
+}
+
+func mergeLabels(labels, extra prometheus.Labels) prometheus.Labels {
+	merged := make(prometheus.Labels, len(labels)+len(extra))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// remoteWriteAPICalls publishes apiCalls to a Prometheus remote_write endpoint using
+// the standard snappy-compressed protobuf WriteRequest.
+func remoteWriteAPICalls(url, identifier string, apiCalls *apiCallMetrics) error {
+	timestampMs := time.Now().UnixNano() / int64(time.Millisecond)
+	var series []prompb.TimeSeries
+
+	addSeries := func(name string, value float64, labels map[string]string) {
+		promLabels := []prompb.Label{
+			{Name: "__name__", Value: name},
+			{Name: "run", Value: identifier},
+		}
+		for k, v := range labels {
+			promLabels = append(promLabels, prompb.Label{Name: k, Value: v})
+		}
+		series = append(series, prompb.TimeSeries{
+			Labels:  promLabels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestampMs}},
+		})
+	}
+
+	for _, apiCall := range apiCalls.sorted() {
+		labels := map[string]string{
+			"verb":        apiCall.Verb,
+			"resource":    apiCall.Resource,
+			"subresource": apiCall.Subresource,
+			"scope":       apiCall.Scope,
+		}
+		for quantile, value := range quantileLatenciesSeconds(apiCall) {
+			addSeries(exportedLatencyMetricName, value, mergeStringLabels(labels, map[string]string{"quantile": quantile}))
+		}
+		addSeries(exportedCountMetricName, float64(apiCall.Count), labels)
+		addSeries(exportedSlowCountMetricName, float64(apiCall.SlowCount), labels)
+	}
+
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: series})
+	if err != nil {
+		return fmt.Errorf("marshalling remote_write request: %v", err)
+	}
+	compressed := snappy.Encode(nil, data)
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending remote_write request to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote_write to %s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func mergeStringLabels(labels, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(extra))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestRemoteWriteAPICalls(t *testing.T) {
+	var gotReq *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotReq = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	apiCalls := newMetricsWithCall("pods", 100, time.Second)
+	if err := remoteWriteAPICalls(server.URL, "run-1", apiCalls); err != nil {
+		t.Fatalf("remoteWriteAPICalls: %v", err)
+	}
+
+	if got := gotReq.Header.Get("Content-Encoding"); got != "snappy" {
+		t.Errorf("Content-Encoding = %q, want snappy", got)
+	}
+	if got := gotReq.Header.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("Content-Type = %q, want application/x-protobuf", got)
+	}
+
+	raw, err := snappy.Decode(nil, gotBody)
+	if err != nil {
+		t.Fatalf("snappy.Decode: %v", err)
+	}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		t.Fatalf("proto.Unmarshal: %v", err)
+	}
+
+	// One series per quantile, plus count and slow count.
+	if want := len(quantileLatenciesSeconds(apiCalls.sorted()[0])) + 2; len(req.Timeseries) != want {
+		t.Fatalf("got %d series, want %d", len(req.Timeseries), want)
+	}
+	for _, ts := range req.Timeseries {
+		labels := map[string]string{}
+		for _, l := range ts.Labels {
+			labels[l.Name] = l.Value
+		}
+		if labels["run"] != "run-1" {
+			t.Errorf("series %v missing run label", ts.Labels)
+		}
+		if labels["resource"] != "pods" {
+			t.Errorf("series %v missing resource=pods label", ts.Labels)
+		}
+	}
+}
+
+func TestRemoteWriteAPICallsPropagatesHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	apiCalls := newMetricsWithCall("pods", 100, time.Second)
+	if err := remoteWriteAPICalls(server.URL, "run-1", apiCalls); err == nil {
+		t.Error("expected an error from a 500 response, got nil")
+	}
+}
+
+func TestExportAPICallsUnknownFormat(t *testing.T) {
+	params := map[string]interface{}{
+		"prometheusExportURL":    "http://example.invalid",
+		"prometheusExportFormat": "carrier-pigeon",
+	}
+	if err := exportAPICalls(params, "run-1", newMetricsWithCall("pods", 100, time.Second)); err == nil {
+		t.Error("expected an error for an unknown prometheusExportFormat, got nil")
+	}
+}
+
+func TestExportAPICallsNoURLIsNoOp(t *testing.T) {
+	if err := exportAPICalls(map[string]interface{}{}, "run-1", newMetricsWithCall("pods", 100, time.Second)); err != nil {
+		t.Errorf("expected no-op when prometheusExportURL is unset, got %v", err)
+	}
+}
+
+func TestMergeStringLabels(t *testing.T) {
+	merged := mergeStringLabels(map[string]string{"a": "1"}, map[string]string{"b": "2"})
+	if merged["a"] != "1" || merged["b"] != "2" || len(merged) != 2 {
+		t.Errorf("got %v, want {a:1 b:2}", merged)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	measurementutil "k8s.io/perf-tests/clusterloader2/pkg/measurement/util"
+	"k8s.io/perf-tests/clusterloader2/pkg/util"
+)
+
+const (
+	// regressionCountFloor is the minimum sample count a key must have, in both the
+	// current and the baseline run, before it's considered for regression checking;
+	// below this the perc99 is too noisy to compare meaningfully.
+	regressionCountFloor = 20
+
+	// defaultRegressionRelativeThreshold flags a call site whose perc99 got at least
+	// 50% slower than the baseline run.
+	defaultRegressionRelativeThreshold = 0.5
+)
+
+// baselineCall is the subset of a previous run's apiCallMetric needed for regression
+// comparison, as recovered from a stored APIResponsivenessPrometheus JSON summary.
+type baselineCall struct {
+	Perc50, Perc90, Perc99 time.Duration
+	Count, SlowCount       int
+}
+
+// loadBaselineSummary reads a previous APIResponsivenessPrometheus JSON summary, as
+// produced by apiCallMetrics.ToPerfData, and indexes it by call site.
+func loadBaselineSummary(path string) (map[string]*baselineCall, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading baselineSummaryPath %q: %v", path, err)
+	}
+	var perfData measurementutil.PerfData
+	if err := json.Unmarshal(raw, &perfData); err != nil {
+		return nil, fmt.Errorf("parsing baseline summary %q: %v", path, err)
+	}
+
+	baseline := make(map[string]*baselineCall, len(perfData.DataItems))
+	for _, item := range perfData.DataItems {
+		key := fmt.Sprintf("%s|%s|%s|%s", item.Labels["Resource"], item.Labels["Subresource"], item.Labels["Verb"], item.Labels["Scope"])
+		count, _ := strconv.Atoi(item.Labels["Count"])
+		slowCount, _ := strconv.Atoi(item.Labels["SlowCount"])
+		baseline[key] = &baselineCall{
+			Perc50:    time.Duration(item.Data["Perc50"] * float64(time.Millisecond)),
+			Perc90:    time.Duration(item.Data["Perc90"] * float64(time.Millisecond)),
+			Perc99:    time.Duration(item.Data["Perc99"] * float64(time.Millisecond)),
+			Count:     count,
+			SlowCount: slowCount,
+		}
+	}
+	return baseline, nil
+}
+
+// detectRegressions compares metrics against the run stored at "baselineSummaryPath"
+// (a no-op when unset) and returns one violation message per call site whose perc99
+// regressed beyond "regressionRelativeThreshold" (default 50%) or, if set,
+// "regressionAbsoluteThresholdSeconds".
+func detectRegressions(params map[string]interface{}, metrics *apiCallMetrics) ([]string, error) {
+	path, err := util.GetStringOrDefault(params, "baselineSummaryPath", "")
+	if err != nil || path == "" {
+		return nil, err
+	}
+	relativeThreshold, err := util.GetFloat64OrDefault(params, "regressionRelativeThreshold", defaultRegressionRelativeThreshold)
+	if err != nil {
+		return nil, err
+	}
+	absoluteThresholdSeconds, err := util.GetFloat64OrDefault(params, "regressionAbsoluteThresholdSeconds", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline, err := loadBaselineSummary(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var regressions []string
+	for _, apiCall := range metrics.sorted() {
+		key := fmt.Sprintf("%s|%s|%s|%s", apiCall.Resource, apiCall.Subresource, apiCall.Verb, apiCall.Scope)
+		base, ok := baseline[key]
+		if !ok || apiCall.Count < regressionCountFloor || base.Count < regressionCountFloor {
+			continue
+		}
+
+		delta := apiCall.Latency.Perc99 - base.Perc99
+		var regressed bool
+		if absoluteThresholdSeconds > 0 {
+			regressed = delta.Seconds() > absoluteThresholdSeconds
+		} else {
+			regressed = base.Perc99 > 0 && float64(delta)/float64(base.Perc99) > relativeThreshold
+		}
+		if !regressed {
+			continue
+		}
+
+		regressions = append(regressions, fmt.Sprintf("%s: perc99 regressed from %v to %v (baseline count=%d, current count=%d)", key, base.Perc99, apiCall.Latency.Perc99, base.Count, apiCall.Count))
+	}
+	return regressions, nil
+}
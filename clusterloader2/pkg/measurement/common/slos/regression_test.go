@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/perf-tests/clusterloader2/pkg/util"
+)
+
+func writeBaseline(t *testing.T, metrics *apiCallMetrics) string {
+	t.Helper()
+	raw, err := util.PrettyPrintJSON(metrics.ToPerfData())
+	if err != nil {
+		t.Fatalf("PrettyPrintJSON: %v", err)
+	}
+	f, err := ioutil.TempFile("", "baseline-*.json")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(raw); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func newMetricsWithCall(resource string, count int, perc99 time.Duration) *apiCallMetrics {
+	m := &apiCallMetrics{metrics: make(map[string]*apiCallMetric)}
+	m.SetLatency(resource, "", "LIST", "namespace", 0.99, perc99)
+	m.SetCount(resource, "", "LIST", "namespace", count)
+	return m
+}
+
+func TestDetectRegressions(t *testing.T) {
+	baselinePath := writeBaseline(t, newMetricsWithCall("pods", 100, 500*time.Millisecond))
+
+	tests := []struct {
+		name      string
+		current   *apiCallMetrics
+		wantCount int
+	}{
+		{
+			name:      "perc99 doubled with p50/p90 unchanged is flagged",
+			current:   newMetricsWithCall("pods", 100, 1*time.Second),
+			wantCount: 1,
+		},
+		{
+			name:      "small perc99 increase within threshold isn't flagged",
+			current:   newMetricsWithCall("pods", 100, 550*time.Millisecond),
+			wantCount: 0,
+		},
+		{
+			name:      "below regressionCountFloor isn't flagged",
+			current:   newMetricsWithCall("pods", 5, 1*time.Second),
+			wantCount: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			regressions, err := detectRegressions(map[string]interface{}{"baselineSummaryPath": baselinePath}, tc.current)
+			if err != nil {
+				t.Fatalf("detectRegressions: %v", err)
+			}
+			if len(regressions) != tc.wantCount {
+				t.Errorf("got %d regressions (%v), want %d", len(regressions), regressions, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestDetectRegressionsNoBaselinePath(t *testing.T) {
+	regressions, err := detectRegressions(map[string]interface{}{}, newMetricsWithCall("pods", 100, time.Second))
+	if err != nil || regressions != nil {
+		t.Errorf("got (%v, %v), want (nil, nil) when baselineSummaryPath is unset", regressions, err)
+	}
+}
+
+func TestDetectRegressionsAbsoluteThreshold(t *testing.T) {
+	baselinePath := writeBaseline(t, newMetricsWithCall("pods", 100, 500*time.Millisecond))
+	params := map[string]interface{}{
+		"baselineSummaryPath":                baselinePath,
+		"regressionAbsoluteThresholdSeconds": 0.1,
+	}
+
+	regressions, err := detectRegressions(params, newMetricsWithCall("pods", 100, 650*time.Millisecond))
+	if err != nil {
+		t.Fatalf("detectRegressions: %v", err)
+	}
+	if len(regressions) != 1 {
+		t.Errorf("150ms absolute delta should clear a 100ms threshold: got %v", regressions)
+	}
+}
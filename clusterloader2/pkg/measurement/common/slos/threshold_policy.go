@@ -0,0 +1,173 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/perf-tests/clusterloader2/pkg/util"
+)
+
+const (
+	// presetK8sOfficial keeps the built-in resource/namespace/cluster thresholds
+	// defined by the upstream sig-scalability SLO.
+	presetK8sOfficial = "k8s-official"
+	// presetStrict halves the built-in thresholds, for tests that want to catch
+	// regressions earlier than the official SLO would.
+	presetStrict = "strict"
+	// presetRelaxed doubles the built-in thresholds, for environments (e.g. CRDs,
+	// aggregated APIs) that are known to be slower than core resources.
+	presetRelaxed = "relaxed"
+)
+
+// presetPolicies are the named policies selectable via the thresholdPolicyPreset param.
+var presetPolicies = map[string]*ThresholdPolicy{
+	presetK8sOfficial: {Multiplier: 1},
+	presetStrict:      {Multiplier: 0.5},
+	presetRelaxed:     {Multiplier: 2},
+}
+
+// ThresholdPolicyOverride customizes the SLO threshold, and optionally the
+// allowed slow call budget, for API calls matching Resource/Subresource/Verb/Scope.
+// Each match field is a regular expression, or a shell glob (path.Match syntax) if
+// prefixed with "glob:"; an empty field matches anything.
+type ThresholdPolicyOverride struct {
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+
+	Perc99Threshold  time.Duration `json:"perc99Threshold"`
+	AllowedSlowCalls int           `json:"allowedSlowCalls,omitempty"`
+}
+
+func (o *ThresholdPolicyOverride) matches(resource, subresource, verb, scope string) bool {
+	return matchField(o.Resource, resource) &&
+		matchField(o.Subresource, subresource) &&
+		matchField(o.Verb, verb) &&
+		matchField(o.Scope, scope)
+}
+
+const globPatternPrefix = "glob:"
+
+func matchField(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasPrefix(pattern, globPatternPrefix) {
+		matched, err := path.Match(strings.TrimPrefix(pattern, globPatternPrefix), value)
+		return err == nil && matched
+	}
+	matched, err := regexp.MatchString("^(?:"+pattern+")$", value)
+	return err == nil && matched
+}
+
+// ThresholdPolicy is a pluggable source of SLO thresholds for apiCallMetric.Validate,
+// consulted before falling back to the built-in resource/namespace/cluster thresholds.
+// It is loaded from the "thresholdPolicyPreset", "thresholdPolicy" (inline YAML) and
+// "thresholdPolicyFile" measurement params; see loadThresholdPolicy.
+type ThresholdPolicy struct {
+	// Overrides are matched in order; the last matching override wins, so more
+	// specific rules should be listed after more general ones.
+	Overrides []ThresholdPolicyOverride `json:"overrides,omitempty"`
+	// Multiplier scales the built-in thresholds when no override matches.
+	// Defaults to 1 (i.e. the official k8s SLO) when unset.
+	Multiplier float64 `json:"multiplier,omitempty"`
+}
+
+// Threshold returns the perc99 threshold and allowed slow call budget that apply
+// to the given call site, consulting overrides before falling back to def scaled
+// by the policy's multiplier.
+func (p *ThresholdPolicy) Threshold(resource, subresource, verb, scope string, def time.Duration) (time.Duration, int) {
+	if p == nil {
+		return def, 0
+	}
+	for i := len(p.Overrides) - 1; i >= 0; i-- {
+		o := &p.Overrides[i]
+		if o.matches(resource, subresource, verb, scope) {
+			return o.Perc99Threshold, o.AllowedSlowCalls
+		}
+	}
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	return time.Duration(float64(def) * multiplier), 0
+}
+
+// HasSlowCallBudget reports whether any override grants a slow-call budget,
+// independent of the top-level allowedSlowCalls param.
+func (p *ThresholdPolicy) HasSlowCallBudget() bool {
+	if p == nil {
+		return false
+	}
+	for _, o := range p.Overrides {
+		if o.AllowedSlowCalls > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// loadThresholdPolicy builds the ThresholdPolicy to use for a measurement run: it
+// starts from the named preset (default "k8s-official") and, if "thresholdPolicy"
+// (inline YAML) or "thresholdPolicyFile" is set, layers its overrides on top.
+func loadThresholdPolicy(params map[string]interface{}) (*ThresholdPolicy, error) {
+	presetName, err := util.GetStringOrDefault(params, "thresholdPolicyPreset", presetK8sOfficial)
+	if err != nil {
+		return nil, err
+	}
+	preset, ok := presetPolicies[presetName]
+	if !ok {
+		return nil, fmt.Errorf("unknown thresholdPolicyPreset %q", presetName)
+	}
+
+	inline, err := util.GetStringOrDefault(params, "thresholdPolicy", "")
+	if err != nil {
+		return nil, err
+	}
+	path, err := util.GetStringOrDefault(params, "thresholdPolicyFile", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	switch {
+	case inline != "":
+		raw = []byte(inline)
+	case path != "":
+		if raw, err = ioutil.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading thresholdPolicyFile %q: %v", path, err)
+		}
+	default:
+		return preset, nil
+	}
+
+	policy := &ThresholdPolicy{Multiplier: preset.Multiplier}
+	if err := yaml.Unmarshal(raw, policy); err != nil {
+		return nil, fmt.Errorf("parsing threshold policy: %v", err)
+	}
+	policy.Overrides = append(append([]ThresholdPolicyOverride{}, preset.Overrides...), policy.Overrides...)
+	return policy, nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholdPolicyThreshold(t *testing.T) {
+	policy := &ThresholdPolicy{
+		Multiplier: 2,
+		Overrides: []ThresholdPolicyOverride{
+			{Resource: "pods", Perc99Threshold: 2 * time.Second, AllowedSlowCalls: 3},
+			{Resource: "crd.*", Verb: "LIST", Perc99Threshold: 10 * time.Second},
+		},
+	}
+
+	threshold, allowed := policy.Threshold("pods", "", "GET", "resource", time.Second)
+	if threshold != 2*time.Second || allowed != 3 {
+		t.Errorf("got (%v, %v), want (2s, 3)", threshold, allowed)
+	}
+
+	threshold, allowed = policy.Threshold("crdthings", "", "LIST", "namespace", time.Second)
+	if threshold != 10*time.Second || allowed != 0 {
+		t.Errorf("got (%v, %v), want (10s, 0)", threshold, allowed)
+	}
+
+	threshold, allowed = policy.Threshold("nodes", "", "GET", "resource", time.Second)
+	if threshold != 2*time.Second || allowed != 0 {
+		t.Errorf("unmatched resource should fall back to def*Multiplier: got (%v, %v), want (2s, 0)", threshold, allowed)
+	}
+
+	if threshold, allowed := (*ThresholdPolicy)(nil).Threshold("pods", "", "GET", "resource", time.Second); threshold != time.Second || allowed != 0 {
+		t.Errorf("nil policy should return def unchanged: got (%v, %v), want (1s, 0)", threshold, allowed)
+	}
+}
+
+func TestThresholdPolicyHasSlowCallBudget(t *testing.T) {
+	if (*ThresholdPolicy)(nil).HasSlowCallBudget() {
+		t.Error("nil policy should have no slow call budget")
+	}
+	if (&ThresholdPolicy{}).HasSlowCallBudget() {
+		t.Error("policy with no overrides should have no slow call budget")
+	}
+	policy := &ThresholdPolicy{Overrides: []ThresholdPolicyOverride{{Resource: "pods", AllowedSlowCalls: 1}}}
+	if !policy.HasSlowCallBudget() {
+		t.Error("policy with an override granting a budget should report one")
+	}
+}
+
+func TestMatchField(t *testing.T) {
+	cases := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"", "anything", true},
+		{"pods", "pods", true},
+		{"pods", "pod", false},
+		{"pod.*", "pods", true},
+		{"pods|nodes", "nodes", true},
+		{"glob:pod*", "pods", true},
+		{"glob:pod*", "nodes", false},
+		{"glob:pod?", "pods", true},
+		{"glob:pod?", "podss", false},
+	}
+	for _, c := range cases {
+		if got := matchField(c.pattern, c.value); got != c.want {
+			t.Errorf("matchField(%q, %q) = %v, want %v", c.pattern, c.value, got, c.want)
+		}
+	}
+}